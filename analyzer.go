@@ -4,9 +4,16 @@
 // account for factors like DC offset in the input signal. It might be
 // good enough to reveal loudness trends, detect when loudness departs
 // from an expected range, etc. YMMV.
+//
+// Analyzer's decoder feeds normalized samples to a handful of
+// built-in stages (RMS, peak, BS.1770 loudness, waveform peak bins);
+// Analyzer.Sinks lets callers add their own stages, built on the
+// Sink/Filter interfaces, to the same decode pass.
 package pcm
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
@@ -25,6 +32,7 @@ type Analyzer struct {
 	Channels     int
 	LittleEndian bool
 	Signed       bool
+	Float        bool // samples are IEEE 754 floats (WordSize must be 32 or 64) rather than signed/unsigned integers
 
 	// Duration of loudness computation window. Typical values are
 	// 400*time.Millisecond (momentary loudness) and 3*time.Second
@@ -38,17 +46,56 @@ type Analyzer struct {
 	// Func to call with current window loudness in dB.
 	ObserveRMS func(rms float64)
 
-	pending   []byte  // bytes written but not yet decoded
-	squares   []int64 // values added to rolling sum
-	nwindow   int64   // window size in #samples
-	sum       int64   // rolling sum
-	next      int     // index (in squares) of next sample
-	countdown int64   // samples until next observe
-	nobserve  int64   // samples per ObserveEvery interval
-
 	// Func to call with peak sample from last interval in dB.
 	ObservePeak func(peak float64)
-	peak        int64 // max sample amplitude since last call to ObservePeak
+
+	// Additional pipeline stages to feed decoded, normalized samples
+	// (range approximately -1..1) through before the built-in
+	// RMS/peak/loudness/waveform stages. Write chains Sinks together
+	// in order (each Filter's output feeding the next; a plain Sink's
+	// input is passed on unchanged to the next stage) and runs the
+	// built-in stages last, so e.g. a DCBlocker placed here cleans the
+	// signal before it's measured.
+	Sinks []Sink
+
+	pending    []byte       // bytes written but not yet decoded
+	readSample sampleReader // reads one sample's amplitude from WordSize/8 bytes
+	sinkHead   Sink         // head of the Sinks+built-in-stages chain
+
+	// Funcs to call with ITU-R BS.1770 / EBU R128 gated loudness
+	// measurements, in LUFS (momentary, short-term, integrated) or
+	// LU (range). These are independent of Window/ObserveEvery: if
+	// any is non-nil, momentary and short-term values are reported
+	// every 100ms of audio, and integrated/range are recomputed at
+	// the same time to reflect the whole stream seen so far (range
+	// only once more than 3s of audio has been seen).
+	ObserveLoudnessMomentary  func(lufs float64)
+	ObserveLoudnessShortTerm  func(lufs float64)
+	ObserveLoudnessIntegrated func(lufs float64)
+	ObserveLoudnessRange      func(lra float64)
+	loud                      *loudnessState
+
+	// Number of waveform peak bins to emit across the stream, for
+	// drawing a waveform overview (as clipper does for audio
+	// scrubbing UIs). Requires Frames, the expected total number of
+	// frames, to size each bin; ignored if ObserveWaveformEvery is
+	// set instead.
+	WaveformBins int
+	Frames       int64
+
+	// Time interval (relative to the audio data) between waveform
+	// bins, for live/streaming use when the total stream length
+	// isn't known ahead of time. Takes precedence over
+	// WaveformBins/Frames if both are set.
+	ObserveWaveformEvery time.Duration
+
+	// Func to call with one waveform peak bin: binIndex starts at 0
+	// and increments by one per call; peaks holds one running
+	// min/max pair per channel, as [min_ch0, max_ch0, min_ch1,
+	// max_ch1, ...]. The final, possibly partial, bin is reported by
+	// Close.
+	ObserveWaveformBin func(binIndex int, peaks []int16)
+	waveform           *waveformState
 
 	wordMax float64 // max sample amplitude for this word size
 }
@@ -56,129 +103,257 @@ type Analyzer struct {
 var ErrBadParameters = errors.New("bad Analyzer parameters")
 
 // UseMIMEType sets the Analyzer's SampleRate, WordSize, Channels,
-// LittleEndian, and Signed fields to match the given MIME type (e.g.,
-// a Content-Type header value).  It returns an error if the MIME type
-// is unsupported or not understood.
+// LittleEndian, Signed, and Float fields to match the given MIME type
+// (e.g., a Content-Type header value). It returns an error if the
+// MIME type is unsupported or not understood.
 //
-// Currently, little-endian signed 16-bit streams are supported, as in
-// "audio/L16; rate=44100; channels=2".
+// Supported types are "audio/L8" (unsigned 8-bit), "audio/L16",
+// "audio/L24", and "audio/L32" (signed little-endian PCM, as in
+// "audio/L16; rate=44100; channels=2"), and the non-standard
+// "audio/pcm; format=float32" / "format=float64" (IEEE 754,
+// little-endian unless "endian=big" is given).
 func (a *Analyzer) UseMIMEType(mt string) error {
-	var rate, channels int64
-	for i, s := range strings.Split(mt, ";") {
+	rate, channels, wordSize, signed, isFloat, littleEndian, err := parseMIMEType(mt)
+	if err != nil {
+		return err
+	}
+	a.SampleRate = rate
+	a.Channels = int(channels)
+	a.WordSize = wordSize
+	a.Signed = signed
+	a.Float = isFloat
+	a.LittleEndian = littleEndian
+	return nil
+}
+
+// parseMIMEType parses mt as accepted by Analyzer.UseMIMEType (and
+// Generator's constructors) into its component fields.
+func parseMIMEType(mt string) (rate, channels int64, wordSize uint, signed, isFloat, littleEndian bool, err error) {
+	var bigEndian bool
+	parts := strings.Split(mt, ";")
+	typ := strings.TrimSpace(parts[0])
+	switch {
+	case strings.HasPrefix(typ, "audio/L8"):
+		wordSize, signed = 8, false
+	case strings.HasPrefix(typ, "audio/L16"):
+		wordSize, signed = 16, true
+	case strings.HasPrefix(typ, "audio/L24"):
+		wordSize, signed = 24, true
+	case strings.HasPrefix(typ, "audio/L32"):
+		wordSize, signed = 32, true
+	case strings.HasPrefix(typ, "audio/pcm"):
+		isFloat = true
+	default:
+		return 0, 0, 0, false, false, false, fmt.Errorf("unrecognized MIME type %q", typ)
+	}
+	for _, s := range parts[1:] {
 		s = strings.TrimSpace(s)
-		if i == 0 {
-			if !strings.HasPrefix(s, "audio/L16") {
-				return fmt.Errorf("unrecognized MIME type %q", s)
-			}
-			continue
-		}
-		kv := strings.Split(strings.ToLower(s), "=")
+		kv := strings.SplitN(strings.ToLower(s), "=", 2)
 		if len(kv) != 2 {
 			continue
 		}
-		var dst *int64
 		switch kv[0] {
 		case "rate":
-			dst = &rate
+			rate, _ = strconv.ParseInt(kv[1], 10, 64)
+			if rate < 1 {
+				return 0, 0, 0, false, false, false, fmt.Errorf("invalid rate %q", kv[1])
+			}
 		case "channels":
-			dst = &channels
-		default:
-			continue
-		}
-		*dst, _ = strconv.ParseInt(kv[1], 10, 64)
-		if *dst < 1 {
-			return fmt.Errorf("invalid %s %q", kv[0], kv[1])
+			channels, _ = strconv.ParseInt(kv[1], 10, 64)
+			if channels < 1 {
+				return 0, 0, 0, false, false, false, fmt.Errorf("invalid channels %q", kv[1])
+			}
+		case "format":
+			switch kv[1] {
+			case "float32":
+				wordSize = 32
+			case "float64":
+				wordSize = 64
+			default:
+				return 0, 0, 0, false, false, false, fmt.Errorf("unsupported format %q", kv[1])
+			}
+		case "endian":
+			switch kv[1] {
+			case "big":
+				bigEndian = true
+			case "little":
+				bigEndian = false
+			default:
+				return 0, 0, 0, false, false, false, fmt.Errorf("invalid endian %q", kv[1])
+			}
 		}
 	}
 	if rate == 0 || channels == 0 {
-		return fmt.Errorf("incomplete header (need rate and channels): %q", mt)
+		return 0, 0, 0, false, false, false, fmt.Errorf("incomplete header (need rate and channels): %q", mt)
 	}
-	a.SampleRate = rate
-	a.Channels = int(channels)
-	a.WordSize = 16
-	a.LittleEndian = true
-	a.Signed = true
-	return nil
+	if isFloat && wordSize == 0 {
+		return 0, 0, 0, false, false, false, fmt.Errorf("incomplete header (need format): %q", mt)
+	}
+	littleEndian = true
+	if isFloat {
+		littleEndian = !bigEndian
+	}
+	return rate, channels, wordSize, signed, isFloat, littleEndian, nil
 }
 
 // Write decodes and analyzes the supplied PCM audio data, calling
 // ObserveRMS and ObservePeak as needed.
+//
+// If UseMIMEType/UseWAVHeader haven't been called yet and the first
+// four bytes written are "RIFF" or "RIFX", Write parses a WAVE
+// header out of p itself, as UseWAVHeader would. This only works if
+// the whole header arrives in a single Write call; a caller that
+// streams small chunks of an unknown-format file should call
+// UseWAVHeader explicitly instead.
 func (a *Analyzer) Write(p []byte) (int, error) {
-	if a.nwindow == 0 {
-		if a.Channels < 1 || a.WordSize == 0 || a.WordSize&7 != 0 || a.WordSize >= 64 || a.SampleRate < 1 || a.SampleRate*int64(a.ObserveEvery)/int64(time.Second) < 1 {
+	n := len(p)
+	if a.SampleRate == 0 && a.readSample == nil && len(a.pending) == 0 && len(p) >= 4 {
+		switch string(p[0:4]) {
+		case "RIFF", "RIFX":
+			br := bytes.NewReader(p)
+			if err := a.UseWAVHeader(br); err != nil {
+				return 0, fmt.Errorf("sniffing WAV header: %w", err)
+			}
+			p = p[len(p)-br.Len():]
+		}
+	}
+	if a.readSample == nil {
+		if a.Channels < 1 || a.WordSize == 0 || a.WordSize&7 != 0 || a.SampleRate < 1 || a.SampleRate*int64(a.ObserveEvery)/int64(time.Second) < 1 {
+			return 0, ErrBadParameters
+		}
+		if a.Float {
+			if a.WordSize != 32 && a.WordSize != 64 {
+				return 0, ErrBadParameters
+			}
+		} else if a.WordSize >= 64 {
 			return 0, ErrBadParameters
 		}
-		a.nwindow = int64(a.Channels) * a.SampleRate * int64(a.Window) / int64(time.Second)
-		if a.Window != a.ObserveEvery {
-			a.squares = make([]int64, 0, int(a.nwindow))
+		if a.Float {
+			a.wordMax = 1
+		} else {
+			a.wordMax = float64(uint64(1) << (a.WordSize - 1))
+		}
+		a.readSample = newSampleReader(a.WordSize, a.LittleEndian, a.Signed, a.Float)
+		if a.ObserveLoudnessMomentary != nil || a.ObserveLoudnessShortTerm != nil || a.ObserveLoudnessIntegrated != nil || a.ObserveLoudnessRange != nil {
+			a.loud = newLoudnessState(a.SampleRate, a.Channels)
+		}
+		if a.ObserveWaveformBin != nil {
+			var framesPerBin int64
+			if a.ObserveWaveformEvery > 0 {
+				framesPerBin = a.SampleRate * int64(a.ObserveWaveformEvery) / int64(time.Second)
+			} else if a.WaveformBins > 0 && a.Frames > 0 {
+				framesPerBin = (a.Frames + int64(a.WaveformBins) - 1) / int64(a.WaveformBins)
+			}
+			if framesPerBin > 0 {
+				a.waveform = newWaveformState(a.Channels, framesPerBin)
+			}
 		}
-		a.next = -1
-		a.wordMax = float64(uint64(1) << (a.WordSize - 1))
-		a.nobserve = a.SampleRate*int64(a.ObserveEvery)/int64(time.Second) - 1
-		a.countdown = a.nobserve
-	}
 
-	var bigshift, littleshift uint
-	if a.LittleEndian {
-		littleshift = 1
-	} else {
-		bigshift = 1
+		stages := append(append([]Sink{}, a.Sinks...), newRMSPeakSink(a))
+		if a.loud != nil {
+			stages = append(stages, &loudnessSink{
+				state:             a.loud,
+				channels:          a.Channels,
+				observeMomentary:  a.ObserveLoudnessMomentary,
+				observeShortTerm:  a.ObserveLoudnessShortTerm,
+				observeIntegrated: a.ObserveLoudnessIntegrated,
+				observeRange:      a.ObserveLoudnessRange,
+			})
+		}
+		if a.waveform != nil {
+			stages = append(stages, &waveformSink{
+				state:    a.waveform,
+				channels: a.Channels,
+				observe:  a.ObserveWaveformBin,
+			})
+		}
+		for i := len(stages) - 2; i >= 0; i-- {
+			next := stages[i+1]
+			if f, ok := stages[i].(Filter); ok {
+				f.SetNext(next)
+				continue
+			}
+			// A plain Sink doesn't forward its input, so pass the
+			// same samples on to the stage after it.
+			s := stages[i]
+			stages[i] = SinkFunc(func(ch int, samples []float64) error {
+				if err := s.WriteSamples(ch, samples); err != nil {
+					return err
+				}
+				return next.WriteSamples(ch, samples)
+			})
+		}
+		a.sinkHead = stages[0]
 	}
-	n := len(p)
+
+	wordBytes := int(a.WordSize) / 8
 	if len(a.pending) > 0 {
 		p = append(a.pending, p...)
 	}
-	for len(p) >= a.Channels*int(a.WordSize)/8 {
+	for len(p) >= a.Channels*wordBytes {
 		for c := 0; c < a.Channels; c++ {
-			var s int64
-			for b := uint(0); b < a.WordSize; b += 8 {
-				s = (s << (bigshift * 8)) | (int64(p[0]) << (littleshift * b))
-				p = p[1:]
-			}
-			if a.Signed {
-				if s&(1<<(a.WordSize-1)) != 0 {
-					s = (s ^ (1<<a.WordSize - 1)) + 1
-				}
-			} else {
-				s -= 1 << (a.WordSize - 1)
-			}
-			square := s * s
-
-			a.sum += square
-			if a.squares != nil {
-				if a.next++; a.next == cap(a.squares) {
-					a.next = 0
-				} else if a.next == len(a.squares) {
-					a.squares = append(a.squares, 0)
-				}
-				a.sum -= a.squares[a.next]
-				a.squares[a.next] = square
+			s := a.readSample(p[:wordBytes])
+			p = p[wordBytes:]
+			normalized := s / a.wordMax
+			if err := a.sinkHead.WriteSamples(c, []float64{normalized}); err != nil {
+				return 0, err
 			}
+		}
+	}
+	a.pending = append([]byte(nil), p...)
+	return n, nil
+}
 
-			if a.peak < s {
-				a.peak = s
-			} else if a.peak < -s {
-				a.peak = -s
-			}
+// Close reports the final waveform peak bin, if it's partially
+// filled and ObserveWaveformBin is set. It has no other effect.
+func (a *Analyzer) Close() error {
+	if a.waveform != nil {
+		a.waveform.flush(a.ObserveWaveformBin)
+	}
+	return nil
+}
+
+// sampleReader decodes one channel sample's amplitude (as a signed
+// integer value, or the float value itself for Float formats) from
+// the first WordSize/8 bytes of word.
+type sampleReader func(word []byte) float64
+
+// newSampleReader returns the sampleReader for the given format,
+// resolved once per Analyzer so the decode loop doesn't have to
+// branch on format for every sample.
+func newSampleReader(wordSize uint, littleEndian, signed, isFloat bool) sampleReader {
+	if isFloat {
+		switch {
+		case wordSize == 32 && littleEndian:
+			return func(word []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(word))) }
+		case wordSize == 32:
+			return func(word []byte) float64 { return float64(math.Float32frombits(binary.BigEndian.Uint32(word))) }
+		case wordSize == 64 && littleEndian:
+			return func(word []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(word)) }
+		default:
+			return func(word []byte) float64 { return math.Float64frombits(binary.BigEndian.Uint64(word)) }
 		}
-		if a.countdown--; a.countdown == 0 {
-			n := a.nwindow
-			if a.squares != nil {
-				n = int64(len(a.squares))
-			}
-			if a.ObserveRMS != nil {
-				a.ObserveRMS(10 * math.Log10(math.Sqrt(float64(a.sum/n))/a.wordMax))
-			}
-			if a.ObservePeak != nil {
-				a.ObservePeak(10 * math.Log10(float64(a.peak)/a.wordMax))
-				a.peak = 0
-			}
-			if a.squares == nil {
-				a.sum = 0
+	}
+	var bigshift, littleshift uint
+	if littleEndian {
+		littleshift = 1
+	} else {
+		bigshift = 1
+	}
+	signBit := int64(1) << (wordSize - 1)
+	return func(word []byte) float64 {
+		var s int64
+		for b := uint(0); b < wordSize; b += 8 {
+			s = (s << (bigshift * 8)) | (int64(word[0]) << (littleshift * b))
+			word = word[1:]
+		}
+		if signed {
+			if s&signBit != 0 {
+				s -= int64(1) << wordSize
 			}
-			a.countdown = a.nobserve
+		} else {
+			s -= signBit
 		}
+		return float64(s)
 	}
-	a.pending = append([]byte(nil), p...)
-	return n, nil
 }
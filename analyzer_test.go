@@ -1,6 +1,7 @@
 package pcm
 
 import (
+	"encoding/binary"
 	"math"
 	"testing"
 	"time"
@@ -45,3 +46,149 @@ func TestObserve(t *testing.T) {
 		}
 	}
 }
+
+func TestObserveLoudness(t *testing.T) {
+	for _, trial := range []struct {
+		frequency        float64
+		scale            float64
+		expectMomentary  float64
+		expectIntegrated float64
+	}{
+		{16000, 32767, -46.089082, -43.773756},
+		{16000, 16384, -52.109359, -49.794084},
+		{16000, 8192, -58.129489, -55.814453},
+	} {
+		var lastMomentary, lastIntegrated float64
+		a := Analyzer{
+			Window:                    400 * time.Millisecond,
+			ObserveEvery:              400 * time.Millisecond,
+			ObserveLoudnessMomentary:  func(lufs float64) { lastMomentary = lufs },
+			ObserveLoudnessIntegrated: func(lufs float64) { lastIntegrated = lufs },
+		}
+		a.UseMIMEType("audio/L16; rate=44100; channels=2")
+		data := make([]byte, 44100*4)
+		for i := 0; i < len(data); i += 4 {
+			s := int16(math.Sin(float64(i)*2*math.Pi/trial.frequency/4) * trial.scale)
+			data[i] = byte(s & 0xff)
+			data[i+1] = byte(s >> 8)
+			data[i+2] = byte(s & 0xff)
+			data[i+3] = byte(s >> 8)
+		}
+		a.Write(data)
+		if math.Abs(lastMomentary-trial.expectMomentary) > 0.000001 {
+			t.Errorf("bad momentary loudness %f (trial %v)", lastMomentary, trial)
+		}
+		if math.Abs(lastIntegrated-trial.expectIntegrated) > 0.000001 {
+			t.Errorf("bad integrated loudness %f (trial %v)", lastIntegrated, trial)
+		}
+	}
+}
+
+// TestObserveLoudnessShortTermRange feeds a quiet segment followed by
+// a loud segment, each long enough to fill the 3s short-term window,
+// and checks that ObserveLoudnessShortTerm tracks the transition and
+// ObserveLoudnessRange reports a non-trivial LRA once both segments
+// have been gated in.
+func TestObserveLoudnessShortTermRange(t *testing.T) {
+	const (
+		quietScale = 8192.0
+		loudScale  = 32767.0
+		segFrames  = 4 * 44100
+	)
+	var shortTerms []float64
+	var lastRange float64
+	a := Analyzer{
+		Window:                   400 * time.Millisecond,
+		ObserveEvery:             400 * time.Millisecond,
+		ObserveLoudnessShortTerm: func(lufs float64) { shortTerms = append(shortTerms, lufs) },
+		ObserveLoudnessRange:     func(lra float64) { lastRange = lra },
+	}
+	a.UseMIMEType("audio/L16; rate=44100; channels=1")
+	data := make([]byte, segFrames*2*2)
+	for i := 0; i < segFrames*2; i++ {
+		scale := quietScale
+		if i >= segFrames {
+			scale = loudScale
+		}
+		s := int16(math.Sin(float64(i*4)*2*math.Pi/16000/4) * scale)
+		data[i*2], data[i*2+1] = byte(s), byte(s>>8)
+	}
+	a.Write(data)
+	if len(shortTerms) == 0 {
+		t.Fatal("ObserveLoudnessShortTerm was never called")
+	}
+	const endOfQuiet = segFrames/4410 - 1 // last hop still entirely within the quiet segment
+	if got, want := shortTerms[endOfQuiet], -61.391313; math.Abs(got-want) > 0.000001 {
+		t.Errorf("short-term loudness at end of quiet segment = %f, want %f", got, want)
+	}
+	if got, want := shortTerms[len(shortTerms)-1], -49.352391; math.Abs(got-want) > 0.000001 {
+		t.Errorf("short-term loudness at end of stream = %f, want %f", got, want)
+	}
+	if got, want := lastRange, 13.3; math.Abs(got-want) > 0.000001 {
+		t.Errorf("loudness range = %f, want %f", got, want)
+	}
+}
+
+// TestFormats decodes the same sine wave (period 16000 frames, about
+// 2.76 Hz at 44.1 kHz) packed into each
+// supported sample format and checks that RMS and peak come out the
+// same (to within format-dependent quantization/rounding error).
+func TestFormats(t *testing.T) {
+	for _, trial := range []struct {
+		mime       string
+		wordSize   int
+		put        func(data []byte, i int, v float64)
+		tolerance  float64
+		expectRMS  float64
+		expectPeak float64
+	}{
+		{"audio/L8; rate=44100; channels=2", 1, func(data []byte, i int, v float64) {
+			data[i] = byte(int8(v/256)) ^ 0x80
+		}, 0.1, -4.510852, -3.0103},
+		{"audio/L16; rate=44100; channels=2", 2, func(data []byte, i int, v float64) {
+			binary.LittleEndian.PutUint16(data[i:], uint16(int16(v)))
+		}, 0.000001, -4.510852, -3.0103},
+		{"audio/L24; rate=44100; channels=2", 3, func(data []byte, i int, v float64) {
+			s := int32(v) * 256
+			data[i], data[i+1], data[i+2] = byte(s), byte(s>>8), byte(s>>16)
+		}, 0.000001, -4.510852, -3.0103},
+		{"audio/L32; rate=44100; channels=2", 4, func(data []byte, i int, v float64) {
+			s := int32(v) * 65536
+			binary.LittleEndian.PutUint32(data[i:], uint32(s))
+		}, 0.000001, -4.510852, -3.0103},
+		{"audio/pcm; format=float32; rate=44100; channels=2", 4, func(data []byte, i int, v float64) {
+			binary.LittleEndian.PutUint32(data[i:], math.Float32bits(float32(v/32768)))
+		}, 0.001, -4.510852, -3.0103},
+		{"audio/pcm; format=float64; rate=44100; channels=2", 8, func(data []byte, i int, v float64) {
+			binary.LittleEndian.PutUint64(data[i:], math.Float64bits(v/32768))
+		}, 0.001, -4.510852, -3.0103},
+		{"audio/pcm; format=float32; endian=big; rate=44100; channels=2", 4, func(data []byte, i int, v float64) {
+			binary.BigEndian.PutUint32(data[i:], math.Float32bits(float32(v/32768)))
+		}, 0.001, -4.510852, -3.0103},
+	} {
+		var lastRMS, lastPeak float64
+		a := Analyzer{
+			Window:       time.Second,
+			ObserveEvery: time.Second,
+			ObserveRMS:   func(rms float64) { lastRMS = rms },
+			ObservePeak:  func(peak float64) { lastPeak = peak },
+		}
+		if err := a.UseMIMEType(trial.mime); err != nil {
+			t.Fatalf("UseMIMEType(%q): %v", trial.mime, err)
+		}
+		const frames = 44100
+		data := make([]byte, frames*2*trial.wordSize)
+		for i := 0; i < frames; i++ {
+			v := math.Sin(float64(i*4)*2*math.Pi/16000/4) * 16384
+			trial.put(data, i*2*trial.wordSize, v)
+			trial.put(data, i*2*trial.wordSize+trial.wordSize, v)
+		}
+		a.Write(data)
+		if math.Abs(lastRMS-trial.expectRMS) > trial.tolerance {
+			t.Errorf("%s: bad RMS %f, want %f", trial.mime, lastRMS, trial.expectRMS)
+		}
+		if math.Abs(lastPeak-trial.expectPeak) > trial.tolerance {
+			t.Errorf("%s: bad peak %f, want %f", trial.mime, lastPeak, trial.expectPeak)
+		}
+	}
+}
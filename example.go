@@ -19,7 +19,10 @@ func main() {
 			fmt.Printf("%*s%f\n", int(rms*40), "|", rms)
 		},
 	}
-	a.UseMIMEType("audio/L16; rate=44100; channels=2")
+	// Write sniffs a RIFF/RIFX WAVE header itself if the first
+	// four bytes of stdin look like one; pipe in raw L16 audio
+	// instead and it must be configured explicitly, e.g.:
+	//   a.UseMIMEType("audio/L16; rate=44100; channels=2")
 	_, err := io.Copy(a, os.Stdin)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,215 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// NoiseKind selects the spectral shape of noise produced by NewNoise.
+type NoiseKind int
+
+const (
+	WhiteNoise NoiseKind = iota
+	PinkNoise
+)
+
+// Generator is an io.Reader that produces PCM audio data -- silence,
+// a sine tone, or noise -- in the format described by a MIME type, as
+// accepted by Analyzer.UseMIMEType. It's meant for building test
+// fixtures and placeholder content, so callers don't have to hand-roll
+// a byte-packing loop.
+type Generator struct {
+	channels     int
+	sampleRate   float64
+	writeSample  sampleWriter
+	wordBytes    int
+	wordMax      float64
+	frame        int64
+	frames       int64 // total frames to emit
+	samplePerCh  func(ch int, frame int64) float64
+	pendingFrame []byte // one encoded frame, partially consumed by Read
+}
+
+// NewSilence returns a Generator that produces d of silence in the
+// format described by mt.
+func NewSilence(mt string, d time.Duration) (*Generator, error) {
+	return newGenerator(mt, d, func(ch int, frame int64) float64 { return 0 })
+}
+
+// NewSine returns a Generator that produces d of a sine tone at the
+// given frequency (Hz) and level (dBFS, i.e. 0 is full scale -- on
+// the same 10*log10(amplitude ratio) scale as Analyzer.ObservePeak,
+// so feeding the result back through an Analyzer reports this dBFS
+// as its peak), in the format described by mt. Every channel carries
+// the same tone.
+func NewSine(mt string, freq, dBFS float64, d time.Duration) (*Generator, error) {
+	g, err := newGenerator(mt, d, nil)
+	if err != nil {
+		return nil, err
+	}
+	amplitude := math.Pow(10, dBFS/10)
+	g.samplePerCh = func(ch int, frame int64) float64 {
+		return amplitude * math.Sin(2*math.Pi*freq*float64(frame)/g.sampleRate)
+	}
+	return g, nil
+}
+
+// NewNoise returns a Generator that produces d of white or pink noise
+// at the given level (dBFS, measured as peak amplitude -- see
+// NewSine), in the format described by mt. Each channel is generated
+// independently.
+func NewNoise(mt string, kind NoiseKind, dBFS float64, d time.Duration) (*Generator, error) {
+	g, err := newGenerator(mt, d, nil)
+	if err != nil {
+		return nil, err
+	}
+	amplitude := math.Pow(10, dBFS/10)
+	rng := rand.New(rand.NewSource(1))
+	switch kind {
+	case PinkNoise:
+		pink := make([]pinkNoiseState, g.channels)
+		g.samplePerCh = func(ch int, frame int64) float64 {
+			return amplitude * pink[ch].step(rng.Float64()*2-1)
+		}
+	default:
+		g.samplePerCh = func(ch int, frame int64) float64 {
+			return amplitude * (rng.Float64()*2 - 1)
+		}
+	}
+	return g, nil
+}
+
+// newGenerator builds the Generator common to all the constructors:
+// it parses mt, sizes the stream to d, and leaves samplePerCh for the
+// caller to fill in (unless gen is already known, as for silence).
+func newGenerator(mt string, d time.Duration, gen func(ch int, frame int64) float64) (*Generator, error) {
+	rate, channels, wordSize, signed, isFloat, littleEndian, err := parseMIMEType(mt)
+	if err != nil {
+		return nil, err
+	}
+	g := &Generator{
+		channels:    int(channels),
+		sampleRate:  float64(rate),
+		writeSample: newSampleWriter(wordSize, littleEndian, signed, isFloat),
+		wordBytes:   int(wordSize) / 8,
+		frames:      int64(d.Seconds() * float64(rate)),
+		samplePerCh: gen,
+	}
+	if isFloat {
+		g.wordMax = 1
+	} else {
+		g.wordMax = float64(uint64(1) << (wordSize - 1))
+	}
+	return g, nil
+}
+
+// Read implements io.Reader, encoding whole frames (one sample per
+// channel) into p and returning io.EOF once d's worth of frames have
+// been produced.
+func (g *Generator) Read(p []byte) (int, error) {
+	frameBytes := g.channels * g.wordBytes
+	var n int
+	for len(g.pendingFrame) > 0 && len(p) > 0 {
+		m := copy(p, g.pendingFrame)
+		p = p[m:]
+		g.pendingFrame = g.pendingFrame[m:]
+		n += m
+	}
+	for len(p) >= frameBytes && g.frame < g.frames {
+		for c := 0; c < g.channels; c++ {
+			g.writeSample(p[:g.wordBytes], g.samplePerCh(c, g.frame)*g.wordMax)
+			p = p[g.wordBytes:]
+			n += g.wordBytes
+		}
+		g.frame++
+	}
+	if len(g.pendingFrame) == 0 && len(p) < frameBytes && g.frame < g.frames {
+		frame := make([]byte, frameBytes)
+		for c := 0; c < g.channels; c++ {
+			g.writeSample(frame[c*g.wordBytes:], g.samplePerCh(c, g.frame)*g.wordMax)
+		}
+		g.frame++
+		m := copy(p, frame)
+		n += m
+		g.pendingFrame = frame[m:]
+	}
+	if g.frame >= g.frames && len(g.pendingFrame) == 0 {
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+	return n, nil
+}
+
+// sampleWriter encodes one channel sample's amplitude (a signed
+// integer value, or the float value itself for Float formats) into
+// the first WordSize/8 bytes of word. It's the Generator-side
+// counterpart of sampleReader.
+type sampleWriter func(word []byte, amplitude float64)
+
+// newSampleWriter returns the sampleWriter for the given format,
+// resolved once per Generator so Read doesn't have to branch on
+// format for every sample.
+func newSampleWriter(wordSize uint, littleEndian, signed, isFloat bool) sampleWriter {
+	if isFloat {
+		switch {
+		case wordSize == 32 && littleEndian:
+			return func(word []byte, amplitude float64) {
+				binary.LittleEndian.PutUint32(word, math.Float32bits(float32(amplitude)))
+			}
+		case wordSize == 32:
+			return func(word []byte, amplitude float64) {
+				binary.BigEndian.PutUint32(word, math.Float32bits(float32(amplitude)))
+			}
+		case wordSize == 64 && littleEndian:
+			return func(word []byte, amplitude float64) { binary.LittleEndian.PutUint64(word, math.Float64bits(amplitude)) }
+		default:
+			return func(word []byte, amplitude float64) { binary.BigEndian.PutUint64(word, math.Float64bits(amplitude)) }
+		}
+	}
+	signBit := int64(1) << (wordSize - 1)
+	return func(word []byte, amplitude float64) {
+		s := int64(amplitude)
+		if s > signBit-1 {
+			s = signBit - 1
+		} else if s < -signBit {
+			s = -signBit
+		}
+		if !signed {
+			s += signBit
+		}
+		u := uint64(s) & (uint64(1)<<wordSize - 1)
+		for b := uint(0); b < wordSize; b += 8 {
+			var shift uint
+			if littleEndian {
+				shift = b
+			} else {
+				shift = wordSize - 8 - b
+			}
+			word[b/8] = byte(u >> shift)
+		}
+	}
+}
+
+// pinkNoiseState implements Paul Kellet's "refined" pink-noise
+// filter, a cheap IIR approximation of a -3dB/octave spectral tilt
+// applied to white noise.
+type pinkNoiseState struct {
+	b0, b1, b2, b3, b4, b5, b6 float64
+}
+
+func (p *pinkNoiseState) step(white float64) float64 {
+	p.b0 = 0.99886*p.b0 + white*0.0555179
+	p.b1 = 0.99332*p.b1 + white*0.0750759
+	p.b2 = 0.96900*p.b2 + white*0.1538520
+	p.b3 = 0.86650*p.b3 + white*0.3104856
+	p.b4 = 0.55000*p.b4 + white*0.5329522
+	p.b5 = -0.7616*p.b5 - white*0.0168980
+	pink := p.b0 + p.b1 + p.b2 + p.b3 + p.b4 + p.b5 + p.b6 + white*0.5362
+	p.b6 = white * 0.115926
+	return pink * 0.11
+}
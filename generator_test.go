@@ -0,0 +1,108 @@
+package pcm
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewSilence(t *testing.T) {
+	g, err := NewSilence("audio/L16; rate=44100; channels=2", time.Second)
+	if err != nil {
+		t.Fatalf("NewSilence: %v", err)
+	}
+	data, err := io.ReadAll(g)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := 44100 * 2 * 2; len(data) != want {
+		t.Fatalf("got %d bytes, want %d", len(data), want)
+	}
+	for _, b := range data {
+		if b != 0 {
+			t.Fatalf("silence produced a non-zero byte")
+		}
+	}
+}
+
+func TestNewSineMatchesAnalyzer(t *testing.T) {
+	g, err := NewSine("audio/L16; rate=44100; channels=2", 1000, -3.0103, time.Second)
+	if err != nil {
+		t.Fatalf("NewSine: %v", err)
+	}
+	data, err := io.ReadAll(g)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := 44100 * 2 * 2; len(data) != want {
+		t.Fatalf("got %d bytes, want %d", len(data), want)
+	}
+	var lastRMS, lastPeak float64
+	a := Analyzer{
+		Window:       time.Second,
+		ObserveEvery: time.Second,
+		ObserveRMS:   func(rms float64) { lastRMS = rms },
+		ObservePeak:  func(peak float64) { lastPeak = peak },
+	}
+	a.UseMIMEType("audio/L16; rate=44100; channels=2")
+	if _, err := a.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if math.Abs(lastPeak-(-3.0103)) > 0.01 {
+		t.Errorf("got peak %f, want -3.0103", lastPeak)
+	}
+	// A full sine cycle's mean square is half its peak square, so RMS
+	// is peak reduced by 10*log10(sqrt(2)) on this package's dB scale.
+	if want := -3.0103 - 10*math.Log10(math.Sqrt2); math.Abs(lastRMS-want) > 0.01 {
+		t.Errorf("got RMS %f, want %f", lastRMS, want)
+	}
+}
+
+func TestNewNoise(t *testing.T) {
+	for _, kind := range []NoiseKind{WhiteNoise, PinkNoise} {
+		g, err := NewNoise("audio/L16; rate=44100; channels=1", kind, -6.0206, time.Second/10)
+		if err != nil {
+			t.Fatalf("NewNoise: %v", err)
+		}
+		data, err := io.ReadAll(g)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if want := 4410 * 2; len(data) != want {
+			t.Fatalf("kind %d: got %d bytes, want %d", kind, len(data), want)
+		}
+		var nonzero bool
+		for i := 0; i < len(data); i += 2 {
+			if data[i] != 0 || data[i+1] != 0 {
+				nonzero = true
+				break
+			}
+		}
+		if !nonzero {
+			t.Errorf("kind %d: noise produced all-zero output", kind)
+		}
+	}
+}
+
+func TestGeneratorSmallReads(t *testing.T) {
+	g, err := NewSine("audio/L16; rate=44100; channels=2", 440, -6, time.Second/10)
+	if err != nil {
+		t.Fatalf("NewSine: %v", err)
+	}
+	var all []byte
+	buf := make([]byte, 3) // deliberately not a multiple of the 4-byte frame size
+	for {
+		n, err := g.Read(buf)
+		all = append(all, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+	if want := 4410 * 2 * 2; len(all) != want {
+		t.Fatalf("got %d bytes via small reads, want %d", len(all), want)
+	}
+}
@@ -0,0 +1,379 @@
+package pcm
+
+import "math"
+
+// loudnessState holds the per-stream state needed to compute
+// ITU-R BS.1770-4 / EBU R128 gated loudness: one K-weighting filter
+// pair per channel, a 400ms/100ms-hop block accumulator, a 3s
+// short-term ring, and bounded loudness histograms used to compute
+// integrated loudness and loudness range without retaining every
+// block ever seen.
+type loudnessState struct {
+	filters []kWeightFilter
+	weight  []float64 // per-channel BS.1770 weight (0 excludes a channel, e.g. LFE)
+
+	blockLen int         // frames per 400ms block
+	hopLen   int64       // frames per 100ms hop
+	hop      int64       // frames until next hop
+	sqSum    []float64   // per-channel sum of filtered-sample squares over the current block
+	sqRing   [][]float64 // per-channel ring buffer of filtered-sample squares, one block long
+	sqNext   int         // shared ring index (all channels advance in lockstep)
+	sqFull   bool
+
+	stRing []float64 // ring of the last 30 (3s) block loudness z-values
+	stSum  float64
+	stNext int
+	stFull bool
+
+	integrated loudnessHistogram
+	lra        loudnessHistogram
+}
+
+// loudnessHistogram accumulates gated-loudness blocks into fixed
+// 0.1 LU bins so integrated loudness and loudness range can be
+// recomputed from a bounded amount of state instead of an
+// ever-growing list of blocks.
+type loudnessHistogram struct {
+	binZSum  []float64
+	binCount []int64
+	ungatedZ float64
+	ungatedN int64
+}
+
+const (
+	loudnessBinFloor = -70.0 // LUFS, absolute gate
+	loudnessBinWidth = 0.1   // LU
+	loudnessBinCeil  = 10.0  // LUFS, clamp for very hot signals
+	loudnessBinCount = int((loudnessBinCeil-loudnessBinFloor)/loudnessBinWidth) + 1
+)
+
+func newLoudnessHistogram() loudnessHistogram {
+	return loudnessHistogram{
+		binZSum:  make([]float64, loudnessBinCount),
+		binCount: make([]int64, loudnessBinCount),
+	}
+}
+
+func loudnessBin(lufs float64) int {
+	i := int((lufs - loudnessBinFloor) / loudnessBinWidth)
+	if i < 0 {
+		i = 0
+	} else if i >= loudnessBinCount {
+		i = loudnessBinCount - 1
+	}
+	return i
+}
+
+// add records a block whose combined, weighted mean square is z, and
+// whose corresponding loudness (computed by the caller) already
+// passed the -70 LUFS absolute gate.
+func (h *loudnessHistogram) add(z, lufs float64) {
+	i := loudnessBin(lufs)
+	h.binZSum[i] += z
+	h.binCount[i]++
+	h.ungatedZ += z
+	h.ungatedN++
+}
+
+// gated returns the relative-gated mean z and total block count at
+// or above it, applying a relative gate of relativeLU below the
+// ungated mean.
+func (h *loudnessHistogram) gated(relativeLU float64) (meanZ float64, n int64) {
+	if h.ungatedN == 0 {
+		return 0, 0
+	}
+	ungatedMeanZ := h.ungatedZ / float64(h.ungatedN)
+	threshold := -0.691 + 10*math.Log10(ungatedMeanZ) + relativeLU
+	first := loudnessBin(threshold)
+	var zSum float64
+	var count int64
+	for i := first; i < loudnessBinCount; i++ {
+		zSum += h.binZSum[i]
+		count += h.binCount[i]
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return zSum / float64(count), count
+}
+
+// percentile returns the loudness, in LUFS, at the given percentile
+// (0..1) of blocks at or above the relative gate.
+func (h *loudnessHistogram) percentile(relativeLU, p float64) float64 {
+	if h.ungatedN == 0 {
+		return 0
+	}
+	ungatedMeanZ := h.ungatedZ / float64(h.ungatedN)
+	threshold := -0.691 + 10*math.Log10(ungatedMeanZ) + relativeLU
+	first := loudnessBin(threshold)
+	var total int64
+	for i := first; i < loudnessBinCount; i++ {
+		total += h.binCount[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	target := int64(p * float64(total))
+	var seen int64
+	for i := first; i < loudnessBinCount; i++ {
+		seen += h.binCount[i]
+		if seen > target {
+			return loudnessBinFloor + (float64(i)+0.5)*loudnessBinWidth
+		}
+	}
+	return loudnessBinCeil
+}
+
+func newLoudnessState(sampleRate int64, channels int) *loudnessState {
+	s := &loudnessState{
+		filters:  make([]kWeightFilter, channels),
+		weight:   make([]float64, channels),
+		blockLen: int(sampleRate * 400 / 1000),
+		hopLen:   sampleRate * 100 / 1000,
+		sqSum:    make([]float64, channels),
+		sqRing:   make([][]float64, channels),
+	}
+	s.hop = s.hopLen
+	for c := range s.filters {
+		s.filters[c] = newKWeightFilter(sampleRate)
+		s.weight[c] = bs1770ChannelWeight(channels, c)
+		s.sqRing[c] = make([]float64, 0, s.blockLen)
+	}
+	s.integrated = newLoudnessHistogram()
+	s.lra = newLoudnessHistogram()
+	return s
+}
+
+// bs1770ChannelWeight returns the BS.1770 weight G for channel index
+// c of a stream with the given channel count, using the standard
+// L/R/C/LFE/Ls/Rs layout for 5- and 6-channel streams. The LFE
+// channel is excluded from loudness measurement. Mono and stereo
+// streams use a weight of 1.0 for every channel.
+func bs1770ChannelWeight(channels, c int) float64 {
+	if channels >= 5 {
+		switch c {
+		case 3: // LFE
+			return 0
+		case 4, 5: // Ls, Rs
+			return 1.41
+		}
+	}
+	return 1.0
+}
+
+// addSample runs one decoded, normalized sample (range approximately
+// -1..1) through channel c's K-weighting filter and folds its square
+// into the current 400ms block.
+func (s *loudnessState) addSample(c int, x float64) {
+	y := s.filters[c].step(x)
+	square := y * y
+	s.sqSum[c] += square
+	ring := s.sqRing[c]
+	if len(ring) < cap(ring) {
+		s.sqRing[c] = append(ring, square)
+	} else {
+		s.sqSum[c] -= ring[s.sqNext]
+		ring[s.sqNext] = square
+	}
+}
+
+// loudnessSink adapts a loudnessState to the Sink/Filter interfaces
+// so Analyzer can splice it into the same ordered chain as its other
+// Sinks: it folds each frame into the gated-loudness accounting, then
+// forwards its input unchanged to the next Sink.
+type loudnessSink struct {
+	state                                                               *loudnessState
+	channels                                                            int
+	observeMomentary, observeShortTerm, observeIntegrated, observeRange func(float64)
+	next                                                                Sink
+}
+
+// SetNext implements Filter.
+func (s *loudnessSink) SetNext(next Sink) { s.next = next }
+
+// WriteSamples implements Filter.
+func (s *loudnessSink) WriteSamples(ch int, samples []float64) error {
+	for _, x := range samples {
+		s.state.addSample(ch, x)
+	}
+	if ch == s.channels-1 {
+		s.state.endFrame(s.observeMomentary, s.observeShortTerm, s.observeIntegrated, s.observeRange)
+	}
+	if s.next == nil {
+		return nil
+	}
+	return s.next.WriteSamples(ch, samples)
+}
+
+// endFrame is called once per decoded frame (i.e., after every
+// channel's addSample). It advances the shared ring index and, every
+// 100ms, emits momentary/short-term loudness and recomputes
+// integrated loudness and loudness range.
+func (s *loudnessState) endFrame(observeMomentary, observeShortTerm, observeIntegrated, observeRange func(float64)) {
+	s.sqNext++
+	if s.sqNext >= cap(s.sqRing[0]) {
+		s.sqNext = 0
+		s.sqFull = true
+	}
+	if s.hop--; s.hop != 0 {
+		return
+	}
+	s.hop = s.hopLen
+
+	n := s.blockLen
+	if !s.sqFull {
+		n = len(s.sqRing[0])
+	}
+	if n == 0 {
+		return
+	}
+	var z float64
+	for c, w := range s.weight {
+		if w == 0 {
+			continue
+		}
+		z += w * s.sqSum[c] / float64(n)
+	}
+
+	momentary := loudnessLUFS(z)
+	if observeMomentary != nil {
+		observeMomentary(momentary)
+	}
+
+	s.pushShortTerm(z)
+	shortTermZ := s.stSum / float64(s.shortTermCount())
+	shortTerm := loudnessLUFS(shortTermZ)
+	if observeShortTerm != nil {
+		observeShortTerm(shortTerm)
+	}
+
+	if z > 0 && momentary >= loudnessBinFloor {
+		s.integrated.add(z, momentary)
+	}
+	if observeIntegrated != nil {
+		meanZ, count := s.integrated.gated(-10)
+		if count > 0 {
+			observeIntegrated(loudnessLUFS(meanZ))
+		} else {
+			observeIntegrated(momentary)
+		}
+	}
+
+	if s.stFull {
+		if shortTermZ > 0 && shortTerm >= loudnessBinFloor {
+			s.lra.add(shortTermZ, shortTerm)
+		}
+		if observeRange != nil {
+			hi := s.lra.percentile(-20, 0.95)
+			lo := s.lra.percentile(-20, 0.10)
+			observeRange(hi - lo)
+		}
+	}
+}
+
+const shortTermBlocks = 30 // 3s / 100ms
+
+func (s *loudnessState) pushShortTerm(z float64) {
+	if s.stRing == nil {
+		s.stRing = make([]float64, 0, shortTermBlocks)
+	}
+	if len(s.stRing) < cap(s.stRing) {
+		s.stRing = append(s.stRing, z)
+		s.stSum += z
+		return
+	}
+	s.stFull = true
+	s.stSum -= s.stRing[s.stNext]
+	s.stSum += z
+	s.stRing[s.stNext] = z
+	if s.stNext++; s.stNext == cap(s.stRing) {
+		s.stNext = 0
+	}
+}
+
+func (s *loudnessState) shortTermCount() int {
+	if s.stFull {
+		return cap(s.stRing)
+	}
+	return len(s.stRing)
+}
+
+// loudnessLUFS converts a BS.1770 weighted mean square z into LUFS.
+func loudnessLUFS(z float64) float64 {
+	if z <= 0 {
+		return loudnessBinFloor
+	}
+	return -0.691 + 10*math.Log10(z)
+}
+
+// kWeightFilter is the two-stage K-weighting filter from ITU-R
+// BS.1770-4 Annex 2: a high-shelf pre-filter followed by an RLB
+// (revised low-frequency B-curve) high-pass filter.
+type kWeightFilter struct {
+	pre, rlb biquad
+}
+
+func newKWeightFilter(sampleRate int64) kWeightFilter {
+	return kWeightFilter{
+		pre: newHighShelfBiquad(float64(sampleRate)),
+		rlb: newRLBBiquad(float64(sampleRate)),
+	}
+}
+
+func (f *kWeightFilter) step(x float64) float64 {
+	return f.rlb.step(f.pre.step(x))
+}
+
+// biquad is a Direct Form I biquadratic IIR filter section.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) step(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// newHighShelfBiquad returns the BS.1770 pre-filter: a high-shelf
+// boost of about +4 dB above ~1681 Hz, derived from the standard
+// analog prototype via the bilinear transform.
+func newHighShelfBiquad(sampleRate float64) biquad {
+	const (
+		f0 = 1681.974450955533
+		g  = 3.999843853973347
+		q  = 0.7071752369554196
+	)
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	return biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+}
+
+// newRLBBiquad returns the BS.1770 RLB filter: a high-pass at
+// roughly 38 Hz, derived from the standard analog prototype via the
+// bilinear transform.
+func newRLBBiquad(sampleRate float64) biquad {
+	const (
+		f0 = 38.13547087602444
+		q  = 0.5003270373238773
+	)
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	a0 := 1 + k/q + k*k
+	return biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+}
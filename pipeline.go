@@ -0,0 +1,183 @@
+package pcm
+
+import (
+	"math"
+	"time"
+)
+
+// Sink receives decoded, normalized PCM samples (range approximately
+// -1..1) one channel at a time, as Write decodes them from the input
+// stream. samples is at least one sample long; callers that need
+// frame boundaries (i.e., knowing when every channel has reported a
+// sample) can count calls against the channel count they were
+// configured with.
+type Sink interface {
+	WriteSamples(ch int, samples []float64) error
+}
+
+// Filter is a Sink that forwards its (possibly transformed) input to
+// another Sink. SetNext must be called before any call to
+// WriteSamples; a Filter with no next Sink silently discards its
+// output.
+type Filter interface {
+	Sink
+	SetNext(next Sink)
+}
+
+// SinkFunc adapts a plain function to a Sink.
+type SinkFunc func(ch int, samples []float64) error
+
+// WriteSamples calls f.
+func (f SinkFunc) WriteSamples(ch int, samples []float64) error { return f(ch, samples) }
+
+// DCBlocker is a Filter that removes DC offset from each channel with
+// a single-pole high-pass (y[n] = x[n] - x[n-1] + R*y[n-1]) before
+// forwarding samples to its next Sink.
+type DCBlocker struct {
+	// R is the pole position, 0 < R < 1; values closer to 1 give a
+	// lower cutoff frequency. Zero means use the default, 0.995.
+	R float64
+
+	next   Sink
+	x1, y1 []float64
+}
+
+// NewDCBlocker returns a DCBlocker with the given pole position, or
+// the default (0.995) if r is zero.
+func NewDCBlocker(r float64) *DCBlocker {
+	if r == 0 {
+		r = 0.995
+	}
+	return &DCBlocker{R: r}
+}
+
+// SetNext sets the Sink that receives this filter's output.
+func (f *DCBlocker) SetNext(next Sink) { f.next = next }
+
+// WriteSamples implements Filter.
+func (f *DCBlocker) WriteSamples(ch int, samples []float64) error {
+	for len(f.x1) <= ch {
+		f.x1 = append(f.x1, 0)
+		f.y1 = append(f.y1, 0)
+	}
+	r := f.R
+	if r == 0 {
+		r = 0.995
+	}
+	x1, y1 := f.x1[ch], f.y1[ch]
+	out := make([]float64, len(samples))
+	for i, x := range samples {
+		y := x - x1 + r*y1
+		out[i] = y
+		x1, y1 = x, y
+	}
+	f.x1[ch], f.y1[ch] = x1, y1
+	if f.next == nil {
+		return nil
+	}
+	return f.next.WriteSamples(ch, out)
+}
+
+// rmsPeakSink is the Filter that backs Analyzer's built-in
+// ObserveRMS/ObservePeak fields: a rolling RMS/peak window over
+// decoded, normalized samples, reported every ObserveEvery. Analyzer
+// splices it into the same ordered Sink/Filter chain as its other
+// Sinks, after any caller-supplied stages.
+type rmsPeakSink struct {
+	observeRMS  func(rms float64)
+	observePeak func(peak float64)
+	isFloat     bool
+	wordMax     float64
+	channels    int64
+
+	squares     []float64
+	nwindow     int64
+	sum         float64
+	next        int
+	sampleCount int64
+	countdown   int64
+	nobserve    int64
+	peak        float64
+	nextSink    Sink
+}
+
+// newRMSPeakSink returns the rmsPeakSink for a, using a's
+// already-validated SampleRate/Channels/Window/ObserveEvery/wordMax.
+func newRMSPeakSink(a *Analyzer) *rmsPeakSink {
+	nwindow := int64(a.Channels) * a.SampleRate * int64(a.Window) / int64(time.Second)
+	nobserve := a.SampleRate*int64(a.ObserveEvery)/int64(time.Second) - 1
+	rp := &rmsPeakSink{
+		observeRMS:  a.ObserveRMS,
+		observePeak: a.ObservePeak,
+		isFloat:     a.Float,
+		wordMax:     a.wordMax,
+		channels:    int64(a.Channels),
+		nwindow:     nwindow,
+		nobserve:    nobserve,
+		countdown:   nobserve,
+		next:        -1,
+	}
+	if a.Window != a.ObserveEvery {
+		rp.squares = make([]float64, 0, int(nwindow))
+	}
+	return rp
+}
+
+// SetNext implements Filter.
+func (rp *rmsPeakSink) SetNext(next Sink) { rp.nextSink = next }
+
+// WriteSamples folds decoded, normalized samples into the rolling
+// RMS/peak window, calling observeRMS/observePeak every nobserve
+// frames, then forwards its input unchanged to the next Sink.
+func (rp *rmsPeakSink) WriteSamples(ch int, samples []float64) error {
+	for _, normalized := range samples {
+		s := normalized * rp.wordMax
+		square := s * s
+		rp.sum += square
+		if rp.squares != nil {
+			if rp.next++; rp.next == cap(rp.squares) {
+				rp.next = 0
+			} else if rp.next == len(rp.squares) {
+				rp.squares = append(rp.squares, 0)
+			}
+			rp.sum -= rp.squares[rp.next]
+			rp.squares[rp.next] = square
+		}
+		if rp.peak < s {
+			rp.peak = s
+		} else if rp.peak < -s {
+			rp.peak = -s
+		}
+		if rp.sampleCount++; rp.sampleCount%rp.channels != 0 {
+			continue
+		}
+		if rp.countdown--; rp.countdown != 0 {
+			continue
+		}
+		n := rp.nwindow
+		if rp.squares != nil {
+			n = int64(len(rp.squares))
+		}
+		meanSquare := rp.sum / float64(n)
+		if !rp.isFloat {
+			// Preserve the truncation that integer division used to
+			// apply here, so integer-format results don't shift.
+			meanSquare = math.Floor(meanSquare)
+		}
+		if rp.observeRMS != nil {
+			rp.observeRMS(10 * math.Log10(math.Sqrt(meanSquare)/rp.wordMax))
+		}
+		if rp.observePeak != nil {
+			rp.observePeak(10 * math.Log10(rp.peak/rp.wordMax))
+			rp.peak = 0
+		}
+		if rp.squares == nil {
+			rp.sum = 0
+		}
+		rp.countdown = rp.nobserve
+	}
+	if rp.nextSink == nil {
+		return nil
+	}
+	return rp.nextSink.WriteSamples(ch, samples)
+}
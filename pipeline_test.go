@@ -0,0 +1,110 @@
+package pcm
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+func TestSinksReceiveNormalizedSamples(t *testing.T) {
+	var calls int
+	var total float64
+	rec := SinkFunc(func(ch int, samples []float64) error {
+		calls++
+		for _, s := range samples {
+			total += s
+		}
+		return nil
+	})
+	a := Analyzer{
+		Window:       time.Second,
+		ObserveEvery: time.Second,
+		Sinks:        []Sink{rec},
+	}
+	if err := a.UseMIMEType("audio/L16; rate=44100; channels=1"); err != nil {
+		t.Fatalf("UseMIMEType: %v", err)
+	}
+	const frames = 100
+	data := make([]byte, frames*2)
+	for i := 0; i < frames; i++ {
+		s := int16(1000)
+		data[i*2], data[i*2+1] = byte(s), byte(s>>8)
+	}
+	if _, err := a.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if calls != frames {
+		t.Errorf("got %d sink calls, want %d", calls, frames)
+	}
+	if want := frames * 1000.0 / 32768; math.Abs(total-want) > 0.0001 {
+		t.Errorf("got total %f, want %f", total, want)
+	}
+}
+
+func TestSinkErrorStopsWrite(t *testing.T) {
+	wantErr := errBoom
+	failing := SinkFunc(func(ch int, samples []float64) error { return wantErr })
+	a := Analyzer{
+		Window:       time.Second,
+		ObserveEvery: time.Second,
+		Sinks:        []Sink{failing},
+	}
+	a.UseMIMEType("audio/L16; rate=44100; channels=1")
+	if _, err := a.Write(make([]byte, 8)); err != wantErr {
+		t.Errorf("Write returned %v, want %v", err, wantErr)
+	}
+}
+
+// TestSinksFeedBuiltinStages checks that a Filter placed in
+// Analyzer.Sinks actually sits upstream of the built-in RMS/peak
+// measurement: a DCBlocker should remove a constant DC offset before
+// ObserveRMS ever sees it.
+func TestSinksFeedBuiltinStages(t *testing.T) {
+	const (
+		frames       = 5000
+		reportFrames = 500 // last report covers only the fully-settled tail
+	)
+	var lastRMS float64
+	a := Analyzer{
+		Window:       reportFrames * time.Second / 44100,
+		ObserveEvery: reportFrames * time.Second / 44100,
+		ObserveRMS:   func(rms float64) { lastRMS = rms },
+		Sinks:        []Sink{NewDCBlocker(0)},
+	}
+	if err := a.UseMIMEType("audio/L16; rate=44100; channels=1"); err != nil {
+		t.Fatalf("UseMIMEType: %v", err)
+	}
+	data := make([]byte, frames*2)
+	for i := 0; i < frames; i++ {
+		s := int16(16384) // constant DC offset, no AC content at all
+		data[i*2], data[i*2+1] = byte(s), byte(s>>8)
+	}
+	if _, err := a.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if lastRMS > -20 {
+		t.Errorf("got RMS %f, want the DCBlocker to have removed most of the constant offset (well below 0 dBFS)", lastRMS)
+	}
+}
+
+func TestDCBlockerRemovesOffset(t *testing.T) {
+	blocker := NewDCBlocker(0)
+	var last float64
+	blocker.SetNext(SinkFunc(func(ch int, samples []float64) error {
+		for _, s := range samples {
+			last = s
+		}
+		return nil
+	}))
+	for i := 0; i < 2000; i++ {
+		if err := blocker.WriteSamples(0, []float64{0.5}); err != nil {
+			t.Fatalf("WriteSamples: %v", err)
+		}
+	}
+	if math.Abs(last) > 0.01 {
+		t.Errorf("DCBlocker didn't settle near zero for a constant input: got %f", last)
+	}
+}
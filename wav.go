@@ -0,0 +1,138 @@
+package pcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// WAVE fmt chunk format codes.
+const (
+	wavFormatPCM        = 1
+	wavFormatIEEEFloat  = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavSubFormatSuffix is the fixed 14-byte KSDATAFORMAT_SUBTYPE suffix
+// shared by the PCM and IEEE-float sub-format GUIDs used inside a
+// WAVEFORMATEXTENSIBLE fmt chunk; only the leading format code
+// differs between them.
+var wavSubFormatSuffix = [14]byte{
+	0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00,
+	0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71,
+}
+
+// UseWAVHeader reads a RIFF/WAVE header from r: the "RIFF"/"RIFX"
+// and "WAVE" IDs, the "fmt " chunk (audio format code, channel
+// count, sample rate, and bits per sample -- including, for
+// WAVEFORMATEXTENSIBLE fmt chunks, the sub-format GUID), skipping
+// any other chunks it encounters along the way. It sets SampleRate,
+// Channels, WordSize, Signed, LittleEndian, and Float accordingly,
+// and returns nil once r is positioned at the start of the "data"
+// chunk's payload, ready for the caller to Write the rest of r.
+//
+// Only uncompressed PCM (format code 1) and IEEE float (format code
+// 3, including via WAVEFORMATEXTENSIBLE) are supported. Compressed
+// codecs such as A-law, mu-law, and ADPCM return an error, since no
+// decoder is registered for them.
+func (a *Analyzer) UseWAVHeader(r io.Reader) error {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return fmt.Errorf("reading RIFF header: %w", err)
+	}
+	var bigEndian bool
+	switch string(riff[0:4]) {
+	case "RIFF":
+	case "RIFX":
+		bigEndian = true
+	default:
+		return fmt.Errorf("not a RIFF/RIFX stream (got %q)", riff[0:4])
+	}
+	if string(riff[8:12]) != "WAVE" {
+		return fmt.Errorf("not a WAVE stream (got %q)", riff[8:12])
+	}
+	byteOrder := binary.ByteOrder(binary.LittleEndian)
+	if bigEndian {
+		byteOrder = binary.BigEndian
+	}
+
+	var haveFormat bool
+	var formatCode, bitsPerSample uint16
+	var channels uint16
+	var sampleRate uint32
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return fmt.Errorf("reading chunk header: %w", err)
+		}
+		id := string(hdr[0:4])
+		size := byteOrder.Uint32(hdr[4:8])
+		switch id {
+		case "fmt ":
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return fmt.Errorf("reading fmt chunk: %w", err)
+			}
+			if len(body) < 16 {
+				return fmt.Errorf("fmt chunk too short (%d bytes)", len(body))
+			}
+			formatCode = byteOrder.Uint16(body[0:2])
+			channels = byteOrder.Uint16(body[2:4])
+			sampleRate = byteOrder.Uint32(body[4:8])
+			bitsPerSample = byteOrder.Uint16(body[14:16])
+			if formatCode == wavFormatExtensible {
+				if len(body) < 40 {
+					return fmt.Errorf("WAVEFORMATEXTENSIBLE fmt chunk too short (%d bytes)", len(body))
+				}
+				if !bytes.Equal(body[26:40], wavSubFormatSuffix[:]) {
+					return fmt.Errorf("unrecognized WAVEFORMATEXTENSIBLE sub-format GUID")
+				}
+				formatCode = byteOrder.Uint16(body[24:26])
+			}
+			if err := skipPad(r, size); err != nil {
+				return err
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return fmt.Errorf("data chunk precedes fmt chunk")
+			}
+			switch formatCode {
+			case wavFormatPCM:
+				a.Float = false
+				a.Signed = bitsPerSample > 8
+			case wavFormatIEEEFloat:
+				a.Float = true
+				a.Signed = true
+			default:
+				return fmt.Errorf("unsupported WAVE audio format code 0x%04x (no decoder registered)", formatCode)
+			}
+			a.SampleRate = int64(sampleRate)
+			a.Channels = int(channels)
+			a.WordSize = uint(bitsPerSample)
+			a.LittleEndian = !bigEndian
+			return nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return fmt.Errorf("skipping %q chunk: %w", id, err)
+			}
+			if err := skipPad(r, size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// skipPad consumes the zero-padding byte that follows an odd-sized
+// RIFF chunk, so chunks stay word-aligned.
+func skipPad(r io.Reader, size uint32) error {
+	if size%2 == 0 {
+		return nil
+	}
+	var pad [1]byte
+	if _, err := io.ReadFull(r, pad[:]); err != nil {
+		return fmt.Errorf("reading chunk pad byte: %w", err)
+	}
+	return nil
+}
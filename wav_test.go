@@ -0,0 +1,137 @@
+package pcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildWAV returns a minimal RIFF/WAVE file containing a sine wave
+// with a period of 16000 frames, optionally wrapped in a
+// WAVEFORMATEXTENSIBLE fmt chunk.
+func buildWAV(t *testing.T, formatCode, bits uint16, channels uint16, rate uint32, extensible bool, frames int) []byte {
+	t.Helper()
+	bytesPerSample := int(bits) / 8
+	dataLen := frames * int(channels) * bytesPerSample
+
+	var fmtChunk bytes.Buffer
+	tag := formatCode
+	if extensible {
+		tag = wavFormatExtensible
+	}
+	binary.Write(&fmtChunk, binary.LittleEndian, tag)
+	binary.Write(&fmtChunk, binary.LittleEndian, channels)
+	binary.Write(&fmtChunk, binary.LittleEndian, rate)
+	binary.Write(&fmtChunk, binary.LittleEndian, rate*uint32(channels)*uint32(bytesPerSample))
+	binary.Write(&fmtChunk, binary.LittleEndian, uint16(int(channels)*bytesPerSample))
+	binary.Write(&fmtChunk, binary.LittleEndian, bits)
+	if extensible {
+		binary.Write(&fmtChunk, binary.LittleEndian, uint16(22)) // cbSize
+		binary.Write(&fmtChunk, binary.LittleEndian, bits)       // validBitsPerSample
+		binary.Write(&fmtChunk, binary.LittleEndian, uint32(0))  // channel mask
+		binary.Write(&fmtChunk, binary.LittleEndian, formatCode) // sub-format code
+		fmtChunk.Write(wavSubFormatSuffix[:])
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+8+fmtChunk.Len()+8+dataLen))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(fmtChunk.Len()))
+	buf.Write(fmtChunk.Bytes())
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataLen))
+
+	data := make([]byte, dataLen)
+	for i := 0; i < frames; i++ {
+		v := math.Sin(float64(i*4)*2*math.Pi/16000/4) * 16384
+		for c := 0; c < int(channels); c++ {
+			off := (i*int(channels) + c) * bytesPerSample
+			switch {
+			case formatCode == wavFormatIEEEFloat && bits == 32:
+				binary.LittleEndian.PutUint32(data[off:], math.Float32bits(float32(v/32768)))
+			case formatCode == wavFormatIEEEFloat && bits == 64:
+				binary.LittleEndian.PutUint64(data[off:], math.Float64bits(v/32768))
+			case bits == 16:
+				binary.LittleEndian.PutUint16(data[off:], uint16(int16(v)))
+			}
+		}
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestUseWAVHeader(t *testing.T) {
+	for _, trial := range []struct {
+		name       string
+		formatCode uint16
+		bits       uint16
+		extensible bool
+	}{
+		{"pcm16", wavFormatPCM, 16, false},
+		{"float32", wavFormatIEEEFloat, 32, false},
+		{"float32-extensible", wavFormatIEEEFloat, 32, true},
+	} {
+		wav := buildWAV(t, trial.formatCode, trial.bits, 2, 44100, trial.extensible, 44100)
+		var lastRMS float64
+		a := Analyzer{
+			Window:       time.Second,
+			ObserveEvery: time.Second,
+			ObserveRMS:   func(rms float64) { lastRMS = rms },
+		}
+		r := bytes.NewReader(wav)
+		if err := a.UseWAVHeader(r); err != nil {
+			t.Fatalf("%s: UseWAVHeader: %v", trial.name, err)
+		}
+		if a.SampleRate != 44100 || a.Channels != 2 || a.Float != (trial.formatCode == wavFormatIEEEFloat) {
+			t.Errorf("%s: bad params after header: rate=%d channels=%d float=%v", trial.name, a.SampleRate, a.Channels, a.Float)
+		}
+		if _, err := a.Write(readAll(t, r)); err != nil {
+			t.Fatalf("%s: Write: %v", trial.name, err)
+		}
+		if math.Abs(lastRMS-(-4.510852)) > 0.01 {
+			t.Errorf("%s: bad RMS %f", trial.name, lastRMS)
+		}
+	}
+}
+
+func TestWriteSniffsWAVHeader(t *testing.T) {
+	wav := buildWAV(t, wavFormatPCM, 16, 2, 44100, false, 44100)
+	var lastRMS float64
+	a := Analyzer{
+		Window:       time.Second,
+		ObserveEvery: time.Second,
+		ObserveRMS:   func(rms float64) { lastRMS = rms },
+	}
+	if _, err := a.Write(wav); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if a.SampleRate != 44100 || a.Channels != 2 {
+		t.Errorf("bad params after sniff: rate=%d channels=%d", a.SampleRate, a.Channels)
+	}
+	if math.Abs(lastRMS-(-4.510852)) > 0.000001 {
+		t.Errorf("bad RMS %f", lastRMS)
+	}
+}
+
+func TestUseWAVHeaderUnsupportedCodec(t *testing.T) {
+	wav := buildWAV(t, 6 /* A-law */, 8, 1, 8000, false, 0)
+	a := Analyzer{}
+	err := a.UseWAVHeader(bytes.NewReader(wav))
+	if err == nil || !strings.Contains(err.Error(), "no decoder registered") {
+		t.Errorf("expected unsupported-codec error, got %v", err)
+	}
+}
+
+func readAll(t *testing.T, r *bytes.Reader) []byte {
+	t.Helper()
+	rest := make([]byte, r.Len())
+	if _, err := r.Read(rest); err != nil {
+		t.Fatalf("reading rest of WAV data: %v", err)
+	}
+	return rest
+}
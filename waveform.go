@@ -0,0 +1,109 @@
+package pcm
+
+// waveformState tracks, per channel, the running min/max sample seen
+// in the current waveform bin, emitting a bin (via endFrame) once
+// framesPerBin frames have been seen.
+type waveformState struct {
+	framesPerBin int64
+	frameCount   int64
+	binIndex     int
+	min, max     []int16
+	peaks        []int16 // reused scratch buffer passed to ObserveWaveformBin
+}
+
+func newWaveformState(channels int, framesPerBin int64) *waveformState {
+	return &waveformState{
+		framesPerBin: framesPerBin,
+		min:          make([]int16, channels),
+		max:          make([]int16, channels),
+		peaks:        make([]int16, channels*2),
+	}
+}
+
+// addSample folds one channel's normalized sample (range
+// approximately -1..1) into the current bin's running min/max.
+func (w *waveformState) addSample(c int, normalized float64) {
+	v := waveformInt16(normalized)
+	if w.frameCount == 0 {
+		w.min[c], w.max[c] = v, v
+		return
+	}
+	if v < w.min[c] {
+		w.min[c] = v
+	}
+	if v > w.max[c] {
+		w.max[c] = v
+	}
+}
+
+// endFrame is called once per decoded frame. Every framesPerBin
+// frames, it calls observe with the bin's peaks and starts a new bin.
+func (w *waveformState) endFrame(observe func(binIndex int, peaks []int16)) {
+	if w.frameCount++; w.frameCount < w.framesPerBin {
+		return
+	}
+	w.emit(observe)
+}
+
+// flush reports the current bin if it has any samples in it, even
+// though it's not full. Called from Analyzer.Close.
+func (w *waveformState) flush(observe func(binIndex int, peaks []int16)) {
+	if w.frameCount > 0 {
+		w.emit(observe)
+	}
+}
+
+func (w *waveformState) emit(observe func(binIndex int, peaks []int16)) {
+	if observe != nil {
+		for c := range w.min {
+			w.peaks[c*2] = w.min[c]
+			w.peaks[c*2+1] = w.max[c]
+		}
+		observe(w.binIndex, w.peaks)
+	}
+	w.binIndex++
+	w.frameCount = 0
+}
+
+// waveformSink adapts a waveformState to the Sink/Filter interfaces
+// so Analyzer can splice it into the same ordered chain as its other
+// Sinks: it folds each frame into the current bin, then forwards its
+// input unchanged to the next Sink.
+type waveformSink struct {
+	state    *waveformState
+	channels int
+	observe  func(binIndex int, peaks []int16)
+	next     Sink
+}
+
+// SetNext implements Filter.
+func (s *waveformSink) SetNext(next Sink) { s.next = next }
+
+// WriteSamples implements Filter.
+func (s *waveformSink) WriteSamples(ch int, samples []float64) error {
+	for _, x := range samples {
+		s.state.addSample(ch, x)
+	}
+	if ch == s.channels-1 {
+		s.state.endFrame(s.observe)
+	}
+	if s.next == nil {
+		return nil
+	}
+	return s.next.WriteSamples(ch, samples)
+}
+
+// waveformInt16 maps a normalized sample (range approximately -1..1,
+// as used for K-weighting) onto the int16 range used for waveform
+// peaks, regardless of the stream's actual word size.
+func waveformInt16(normalized float64) int16 {
+	v := normalized * 32767
+	switch {
+	case v > 32767:
+		return 32767
+	case v < -32768:
+		return -32768
+	default:
+		return int16(v)
+	}
+}
@@ -0,0 +1,88 @@
+package pcm
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestObserveWaveformBin(t *testing.T) {
+	const frames = 44100
+	var bins [][]int16
+	a := Analyzer{
+		Window:       time.Second,
+		ObserveEvery: time.Second,
+		WaveformBins: 10,
+		Frames:       frames,
+		ObserveWaveformBin: func(binIndex int, peaks []int16) {
+			if binIndex != len(bins) {
+				t.Errorf("bin %d reported out of order (got index %d)", len(bins), binIndex)
+			}
+			bins = append(bins, append([]int16(nil), peaks...))
+		},
+	}
+	a.UseMIMEType("audio/L16; rate=44100; channels=2")
+	data := make([]byte, frames*4)
+	for i := 0; i < frames; i++ {
+		s := int16(math.Sin(float64(i*4)*2*math.Pi/16000/4) * 32767)
+		data[i*4], data[i*4+1] = byte(s), byte(s>>8)
+		data[i*4+2], data[i*4+3] = byte(s), byte(s>>8)
+	}
+	if _, err := a.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(bins) != 10 {
+		t.Fatalf("got %d bins, want 10", len(bins))
+	}
+	var overallMin, overallMax int16
+	for i, peaks := range bins {
+		if len(peaks) != 4 {
+			t.Fatalf("bin %d: got %d peaks, want 4", i, len(peaks))
+		}
+		if peaks[0] != peaks[2] || peaks[1] != peaks[3] {
+			t.Errorf("bin %d: channels should match for this identical stereo signal: %v", i, peaks)
+		}
+		if peaks[0] < overallMin {
+			overallMin = peaks[0]
+		}
+		if peaks[1] > overallMax {
+			overallMax = peaks[1]
+		}
+	}
+	if overallMin >= 0 || overallMax <= 0 {
+		t.Errorf("expected a negative min and positive max across the whole sine wave, got min=%d max=%d", overallMin, overallMax)
+	}
+}
+
+func TestObserveWaveformEveryAndClose(t *testing.T) {
+	const frames = 44100
+	var bins [][]int16
+	a := Analyzer{
+		Window:               time.Second,
+		ObserveEvery:         time.Second,
+		ObserveWaveformEvery: 100 * time.Millisecond,
+		ObserveWaveformBin: func(binIndex int, peaks []int16) {
+			bins = append(bins, append([]int16(nil), peaks...))
+		},
+	}
+	a.UseMIMEType("audio/L16; rate=44100; channels=1")
+	// One second minus one frame, so the final 100ms bin is partial
+	// and only reported by Close.
+	data := make([]byte, (frames-1)*2)
+	for i := 0; i < frames-1; i++ {
+		s := int16(math.Sin(float64(i*4)*2*math.Pi/16000/4) * 32767)
+		data[i*2], data[i*2+1] = byte(s), byte(s>>8)
+	}
+	if _, err := a.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(bins) != 9 {
+		t.Fatalf("got %d bins before Close, want 9", len(bins))
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(bins) != 10 {
+		t.Fatalf("got %d bins after Close, want 10", len(bins))
+	}
+}